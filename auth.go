@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+// scope is a capability a token or the shared admin password can grant.
+// admin is implied by basic auth and satisfies every route, for backward
+// compatibility with operators who only have the password.
+type scope string
+
+const (
+	scopeStart       scope = "start"
+	scopeStop        scope = "stop"
+	scopeUpdate      scope = "update"
+	scopeRestoreSave scope = "restoresave"
+	scopeRead        scope = "read"
+	scopeAdmin       scope = "admin"
+)
+
+// TokenConfig is one entry of config.json's "tokens" list: a bearer token
+// identified by the sha256 hex digest of its secret (never the secret
+// itself), the scopes it's allowed to use, and a display name for audit
+// logging.
+type TokenConfig struct {
+	Name   string  `json:"name"`
+	Hash   string  `json:"hash"`
+	Scopes []scope `json:"scopes"`
+}
+
+// allows reports whether t is permitted to use s, either directly or via
+// the admin scope.
+func (t *TokenConfig) allows(s scope) bool {
+	for _, have := range t.Scopes {
+		if have == s || have == scopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// hashToken returns the hex sha256 digest of a bearer token secret, the
+// form config.json stores so a leaked config doesn't leak usable tokens.
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticator gates routes behind either a scoped bearer token or the
+// legacy shared password, the latter always granted the admin scope.
+type Authenticator struct {
+	password string
+	byHash   map[string]*TokenConfig
+}
+
+// NewAuthenticator builds an Authenticator from config's password and
+// token list.
+func NewAuthenticator(config *Config) *Authenticator {
+	a := &Authenticator{
+		password: config.Password,
+		byHash:   make(map[string]*TokenConfig, len(config.Tokens)),
+	}
+	for i := range config.Tokens {
+		t := &config.Tokens[i]
+		a.byHash[t.Hash] = t
+	}
+	return a
+}
+
+// authenticate checks r's credentials and reports the principal name to
+// attribute the request to, if it's allowed to use required.
+func (a *Authenticator) authenticate(r *http.Request, required scope) (string, bool) {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		secret := strings.TrimPrefix(header, "Bearer ")
+		token, ok := a.byHash[hashToken(secret)]
+		if !ok || !token.allows(required) {
+			return "", false
+		}
+		return token.Name, true
+	}
+
+	user, pass, ok := r.BasicAuth()
+	if !ok || user == "" || a.password == "" || subtle.ConstantTimeCompare([]byte(pass), []byte(a.password)) != 1 {
+		return "", false
+	}
+	return "admin", true
+}
+
+type contextKey string
+
+// principalContextKey is the context key requireScope stores the
+// authenticated principal's name under, for audit logging.
+const principalContextKey contextKey = "principal"
+
+// principalName returns the name requireScope authenticated r's request
+// as, or "unknown" if r reached here by some other path.
+func principalName(r *http.Request) string {
+	if name, ok := r.Context().Value(principalContextKey).(string); ok {
+		return name
+	}
+	return "unknown"
+}
+
+// requireScope returns middleware that authenticates the request via
+// bearer token or basic auth and rejects it unless the result is allowed
+// to use s.
+func (a *Authenticator) requireScope(s scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name, ok := a.authenticate(r, s)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Basic realm="auth"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				render.Render(w, r, NewResponse(false, "Unauthorized"))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey, name)))
+		})
+	}
+}
+
+// auditRecorder captures a handler's response body so audit can pull the
+// job id back out of it without changing what's sent to the client.
+type auditRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (rec *auditRecorder) Write(p []byte) (int, error) {
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+// audit wraps a mutating route so every authenticated call to it is
+// recorded as one JSON line: which token (or "admin") made it, from
+// where, on which route, and the job id it produced, so save-restores
+// and restarts are attributable to a specific operator.
+func audit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &auditRecorder{ResponseWriter: w}
+		next(rec, r)
+
+		var resp struct {
+			Message struct {
+				JobID string `json:"job_id"`
+			} `json:"message"`
+		}
+		json.Unmarshal(rec.body.Bytes(), &resp)
+
+		entry, err := json.Marshal(map[string]string{
+			"time":      time.Now().UTC().Format(time.RFC3339),
+			"token":     principalName(r),
+			"remote_ip": r.RemoteAddr,
+			"route":     r.URL.Path,
+			"job_id":    resp.Message.JobID,
+		})
+		if err == nil {
+			fmt.Println(string(entry))
+		}
+	}
+}
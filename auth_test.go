@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestAuthenticator() *Authenticator {
+	return NewAuthenticator(&Config{
+		Password: "adminpass",
+		Tokens: []TokenConfig{
+			{Name: "ci", Hash: hashToken("ci-token"), Scopes: []scope{scopeUpdate, scopeRead}},
+			{Name: "root", Hash: hashToken("root-token"), Scopes: []scope{scopeAdmin}},
+		},
+	})
+}
+
+func TestAuthenticateBearerToken(t *testing.T) {
+	a := newTestAuthenticator()
+
+	tests := []struct {
+		name     string
+		token    string
+		required scope
+		wantName string
+		wantOK   bool
+	}{
+		{"token with required scope", "ci-token", scopeUpdate, "ci", true},
+		{"token without required scope", "ci-token", scopeRestoreSave, "", false},
+		{"admin-scoped token satisfies any scope", "root-token", scopeRestoreSave, "root", true},
+		{"unknown token", "bogus", scopeRead, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+
+			name, ok := a.authenticate(r, tt.required)
+			if ok != tt.wantOK || name != tt.wantName {
+				t.Errorf("authenticate() = (%q, %v), want (%q, %v)", name, ok, tt.wantName, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAuthenticateBasicAuth(t *testing.T) {
+	a := newTestAuthenticator()
+
+	tests := []struct {
+		name     string
+		user     string
+		pass     string
+		setAuth  bool
+		wantName string
+		wantOK   bool
+	}{
+		{"correct password", "admin", "adminpass", true, "admin", true},
+		{"wrong password", "admin", "wrong", true, "", false},
+		{"empty password", "admin", "", true, "", false},
+		{"empty username", "", "adminpass", true, "", false},
+		{"no credentials", "", "", false, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.setAuth {
+				r.SetBasicAuth(tt.user, tt.pass)
+			}
+
+			name, ok := a.authenticate(r, scopeRead)
+			if ok != tt.wantOK || name != tt.wantName {
+				t.Errorf("authenticate() = (%q, %v), want (%q, %v)", name, ok, tt.wantName, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAuthenticateRejectsBasicAuthWhenNoPasswordConfigured(t *testing.T) {
+	a := NewAuthenticator(&Config{})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("admin", "")
+
+	if _, ok := a.authenticate(r, scopeRead); ok {
+		t.Error("authenticate() = ok, want rejected when no password is configured")
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	a := newTestAuthenticator()
+	handler := a.requireScope(scopeUpdate)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Run("allowed", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/update", nil)
+		r.Header.Set("Authorization", "Bearer ci-token")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/update", nil)
+		r.Header.Set("Authorization", "Bearer bogus")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+}
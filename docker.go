@@ -0,0 +1,335 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/container"
+	"docker.io/go-docker/api/types/network"
+)
+
+const (
+	ModeScript = "script"
+	ModeDocker = "docker"
+)
+
+const (
+	defaultStopTimeoutSeconds   = 10
+	defaultHealthTimeoutSeconds = 30
+)
+
+func (m *Monitor) dockerMode() bool {
+	return m.Conf.Mode == ModeDocker
+}
+
+func (m *Monitor) stopTimeout() *time.Duration {
+	seconds := m.Conf.StopTimeoutSeconds
+	if seconds <= 0 {
+		seconds = defaultStopTimeoutSeconds
+	}
+	d := time.Duration(seconds) * time.Second
+	return &d
+}
+
+// startRunner, stopRunner and updateRunner pick the script or Docker
+// implementation for a job, depending on Conf.Mode.
+
+func (m *Monitor) startRunner() jobRunner {
+	if m.dockerMode() {
+		return m.startContainer
+	}
+	return func(job *Job) (int, error) {
+		return runScript(job, m.Conf.StartScript)
+	}
+}
+
+func (m *Monitor) stopRunner() jobRunner {
+	if m.dockerMode() {
+		return m.stopContainer
+	}
+	return func(job *Job) (int, error) {
+		return runScript(job, m.Conf.StopScript)
+	}
+}
+
+func (m *Monitor) updateRunner() jobRunner {
+	if m.dockerMode() {
+		return m.updateContainer
+	}
+	return func(job *Job) (int, error) {
+		return runScript(job, m.Conf.UpdateScript)
+	}
+}
+
+func (m *Monitor) startContainer(job *Job) (int, error) {
+	ctx := context.Background()
+
+	job.appendLog(fmt.Sprintf("starting container %s", m.Conf.Container))
+	if err := m.Docker.ContainerStart(ctx, m.Conf.Container, types.ContainerStartOptions{}); err != nil {
+		return -1, err
+	}
+	job.appendLog("container started")
+	return 0, nil
+}
+
+func (m *Monitor) stopContainer(job *Job) (int, error) {
+	ctx := context.Background()
+
+	job.appendLog(fmt.Sprintf("stopping container %s", m.Conf.Container))
+	if err := m.Docker.ContainerStop(ctx, m.Conf.Container, m.stopTimeout()); err != nil {
+		return -1, err
+	}
+	job.appendLog("container stopped")
+	return 0, nil
+}
+
+// updateContainer pulls (or builds) a new image, recreates the server
+// container with it, and rolls back to the previous image if the new
+// container fails its health check.
+func (m *Monitor) updateContainer(job *Job) (int, error) {
+	ctx := context.Background()
+
+	info, err := m.Docker.ContainerInspect(ctx, m.Conf.Container)
+	if err != nil {
+		return -1, fmt.Errorf("failed to inspect running container: %v", err)
+	}
+	previousImage := info.Config.Image
+
+	image, err := m.pullOrBuildImage(ctx, job)
+	if err != nil {
+		return -1, fmt.Errorf("failed to pull/build image: %v", err)
+	}
+
+	newConfig := *info.Config
+	newConfig.Image = image
+
+	job.appendLog(fmt.Sprintf("stopping %s", m.Conf.Container))
+	if err := m.Docker.ContainerStop(ctx, m.Conf.Container, m.stopTimeout()); err != nil {
+		return -1, fmt.Errorf("failed to stop container: %v", err)
+	}
+	if err := m.Docker.ContainerRemove(ctx, m.Conf.Container, types.ContainerRemoveOptions{}); err != nil {
+		return -1, fmt.Errorf("failed to remove container: %v", err)
+	}
+
+	netConfig := &network.NetworkingConfig{EndpointsConfig: info.NetworkSettings.Networks}
+
+	if err := m.recreateAndStart(ctx, job, &newConfig, info.HostConfig, netConfig); err != nil {
+		job.appendLog(fmt.Sprintf("update failed, rolling back to %s: %v", previousImage, err))
+
+		rollbackConfig := newConfig
+		rollbackConfig.Image = previousImage
+		if rbErr := m.recreateAndStart(ctx, job, &rollbackConfig, info.HostConfig, netConfig); rbErr != nil {
+			return -1, fmt.Errorf("update failed (%v) and rollback failed (%v)", err, rbErr)
+		}
+		return -1, fmt.Errorf("update failed, rolled back to %s: %v", previousImage, err)
+	}
+
+	job.appendLog("update succeeded")
+	return 0, nil
+}
+
+func (m *Monitor) recreateAndStart(ctx context.Context, job *Job, config *container.Config, hostConfig *container.HostConfig, netConfig *network.NetworkingConfig) error {
+	created, err := m.Docker.ContainerCreate(ctx, config, hostConfig, netConfig, m.Conf.Container)
+	if err != nil {
+		return fmt.Errorf("failed to create container: %v", err)
+	}
+
+	if err := m.Docker.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		return fmt.Errorf("failed to start container: %v", err)
+	}
+
+	if err := m.waitHealthy(ctx, created.ID); err != nil {
+		m.Docker.ContainerStop(ctx, created.ID, m.stopTimeout())
+		m.Docker.ContainerRemove(ctx, created.ID, types.ContainerRemoveOptions{})
+		return err
+	}
+
+	job.appendLog(fmt.Sprintf("container %s running as %s", m.Conf.Container, created.ID))
+	return nil
+}
+
+// waitHealthy polls a container until it reports healthy (or, absent a
+// healthcheck, simply running), or returns an error once it exits, reports
+// unhealthy, or the configured timeout elapses.
+func (m *Monitor) waitHealthy(ctx context.Context, containerID string) error {
+	timeoutSeconds := m.Conf.HealthTimeoutSeconds
+	if timeoutSeconds <= 0 {
+		timeoutSeconds = defaultHealthTimeoutSeconds
+	}
+	deadline := time.Now().Add(time.Duration(timeoutSeconds) * time.Second)
+
+	for {
+		info, err := m.Docker.ContainerInspect(ctx, containerID)
+		if err != nil {
+			return fmt.Errorf("health check failed to inspect container: %v", err)
+		}
+		if !info.State.Running {
+			return fmt.Errorf("container exited during health check (code %d)", info.State.ExitCode)
+		}
+		if info.State.Health == nil || info.State.Health.Status == types.Healthy {
+			return nil
+		}
+		if info.State.Health.Status == types.Unhealthy {
+			return fmt.Errorf("container reported unhealthy")
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("health check timed out after %ds", timeoutSeconds)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// pullOrBuildImage pulls Conf.Image if set, otherwise builds an image from
+// Conf.GitDir, tagged as "<container>:latest".
+func (m *Monitor) pullOrBuildImage(ctx context.Context, job *Job) (string, error) {
+	if m.Conf.Image != "" {
+		job.appendLog(fmt.Sprintf("pulling %s", m.Conf.Image))
+		reader, err := m.Docker.ImagePull(ctx, m.Conf.Image, types.ImagePullOptions{})
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		if err := logDockerStream(job, reader); err != nil {
+			return "", fmt.Errorf("pull failed: %v", err)
+		}
+		return m.Conf.Image, nil
+	}
+
+	tag := fmt.Sprintf("%s:latest", m.Conf.Container)
+	job.appendLog(fmt.Sprintf("building %s from %s", tag, m.Conf.GitDir))
+
+	buildContext, err := tarDirectory(m.Conf.GitDir)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := m.Docker.ImageBuild(ctx, buildContext, types.ImageBuildOptions{Tags: []string{tag}})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := logDockerStream(job, resp.Body); err != nil {
+		return "", fmt.Errorf("build failed: %v", err)
+	}
+
+	return tag, nil
+}
+
+// dockerStreamMessage is one line of the newline-delimited JSON progress
+// stream the Docker API returns from ImagePull and ImageBuild.
+type dockerStreamMessage struct {
+	Stream      string `json:"stream"`
+	Status      string `json:"status"`
+	Progress    string `json:"progress"`
+	ID          string `json:"id"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// logDockerStream decodes r as a Docker JSON progress stream, appending each
+// message's human-readable text to job's log, and returns an error if the
+// stream itself reports one (ImagePull/ImageBuild otherwise return a nil
+// error for a failed pull/build, since the failure is only visible inside
+// the stream).
+func logDockerStream(job *Job, r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for {
+		var msg dockerStreamMessage
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to decode docker stream: %v", err)
+		}
+
+		if msg.Error != "" {
+			if msg.ErrorDetail.Message != "" {
+				return fmt.Errorf("%s", msg.ErrorDetail.Message)
+			}
+			return fmt.Errorf("%s", msg.Error)
+		}
+
+		switch {
+		case msg.Stream != "":
+			job.appendLog(strings.TrimRight(msg.Stream, "\n"))
+		case msg.Status != "":
+			line := msg.Status
+			if msg.ID != "" {
+				line = fmt.Sprintf("%s: %s", msg.ID, line)
+			}
+			if msg.Progress != "" {
+				line = fmt.Sprintf("%s %s", line, msg.Progress)
+			}
+			job.appendLog(line)
+		}
+	}
+}
+
+// tarDirectory packs dir into an in-memory tar stream suitable for use as a
+// Docker build context.
+func tarDirectory(dir string) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	return &buf, nil
+}
+
+// ContainerStatus is the structured result of /is_running.
+type ContainerStatus struct {
+	Running      bool   `json:"running"`
+	Status       string `json:"status,omitempty"`
+	ExitCode     int    `json:"exit_code,omitempty"`
+	OOMKilled    bool   `json:"oom_killed,omitempty"`
+	RestartCount int    `json:"restart_count,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
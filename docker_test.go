@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"docker.io/go-docker"
+)
+
+func TestLogDockerStream(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		wantErr bool
+		wantLog []string
+	}{
+		{
+			name:    "build progress",
+			stream:  `{"stream":"Step 1/2 : FROM golang\n"}` + "\n" + `{"stream":"Successfully built abc123\n"}` + "\n",
+			wantLog: []string{"Step 1/2 : FROM golang", "Successfully built abc123"},
+		},
+		{
+			name:    "pull status with progress",
+			stream:  `{"status":"Downloading","progress":"[===>] 1/2","id":"layer1"}` + "\n",
+			wantLog: []string{"layer1: Downloading [===>] 1/2"},
+		},
+		{
+			name:    "embedded error with detail",
+			stream:  `{"errorDetail":{"message":"failed to build: no such file"},"error":"failed to build: no such file"}` + "\n",
+			wantErr: true,
+		},
+		{
+			name:    "embedded error without detail",
+			stream:  `{"error":"pull access denied"}` + "\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &Job{subscribers: make(map[chan string]struct{})}
+			err := logDockerStream(job, strings.NewReader(tt.stream))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("logDockerStream() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := job.log; !equalStrings(got, tt.wantLog) {
+				t.Errorf("job log = %v, want %v", got, tt.wantLog)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeDockerDaemon is a minimal stand-in for the Docker Engine API, enough
+// to drive updateContainer through a build failure and a start-failure
+// rollback without a real daemon.
+type fakeDockerDaemon struct {
+	mu            sync.Mutex
+	buildErr      string
+	startFails    bool
+	startedImages map[string]string // container id -> image it was created with
+	nextContainer int
+	createCalls   []string
+}
+
+func (f *fakeDockerDaemon) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/containers/game/json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"Id":    "game",
+			"State": map[string]interface{}{"Running": true},
+			"Config": map[string]interface{}{
+				"Image": "myrepo/game:v1",
+			},
+			"HostConfig":      map[string]interface{}{},
+			"NetworkSettings": map[string]interface{}{"Networks": map[string]interface{}{}},
+		})
+	})
+
+	mux.HandleFunc("/containers/game/stop", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/containers/game", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/images/create", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"Pull complete"}`+"\n")
+	})
+
+	mux.HandleFunc("/build", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		buildErr := f.buildErr
+		f.mu.Unlock()
+
+		if buildErr != "" {
+			fmt.Fprintf(w, `{"error":%q}`+"\n", buildErr)
+			return
+		}
+		fmt.Fprint(w, `{"stream":"Successfully built abc123\n"}`+"\n")
+	})
+
+	mux.HandleFunc("/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Image string
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		f.mu.Lock()
+		f.nextContainer++
+		id := fmt.Sprintf("new-%d", f.nextContainer)
+		f.createCalls = append(f.createCalls, body.Image)
+		f.startedImages[id] = body.Image
+		f.mu.Unlock()
+
+		writeJSON(w, map[string]interface{}{"Id": id})
+	})
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/containers/"), "/start")
+			f.mu.Lock()
+			fail := f.startFails && strings.HasPrefix(id, "new-1")
+			f.mu.Unlock()
+			if fail {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, `{"message":"no such image"}`)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			// health check inspect for a freshly created container.
+			writeJSON(w, map[string]interface{}{
+				"Id":    strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/containers/"), "/json"),
+				"State": map[string]interface{}{"Running": true},
+			})
+		case strings.HasPrefix(r.URL.Path, "/containers/"):
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestUpdateContainerRollsBackOnStartFailure(t *testing.T) {
+	daemon := &fakeDockerDaemon{startFails: true, startedImages: make(map[string]string)}
+	server := httptest.NewServer(daemon.handler())
+	defer server.Close()
+
+	client, err := docker.NewClient(server.URL, "", server.Client(), nil)
+	if err != nil {
+		t.Fatalf("docker.NewClient() error = %v", err)
+	}
+
+	m := &Monitor{
+		Name:   "default",
+		Conf:   &InstanceConfig{Container: "game", Image: "myrepo/game:v2", HealthTimeoutSeconds: 1},
+		Docker: client,
+	}
+	job := &Job{subscribers: make(map[chan string]struct{})}
+
+	_, err = m.updateContainer(job)
+	if err == nil {
+		t.Fatal("updateContainer() error = nil, want rollback failure reported")
+	}
+	if !strings.Contains(err.Error(), "rolled back") {
+		t.Errorf("updateContainer() error = %v, want it to mention the rollback", err)
+	}
+
+	daemon.mu.Lock()
+	defer daemon.mu.Unlock()
+	if len(daemon.createCalls) != 2 {
+		t.Fatalf("containers created = %d, want 2 (failed attempt + rollback)", len(daemon.createCalls))
+	}
+	if daemon.createCalls[0] != "myrepo/game:v2" {
+		t.Errorf("first create image = %q, want the pulled image", daemon.createCalls[0])
+	}
+	if daemon.createCalls[1] != "myrepo/game:v1" {
+		t.Errorf("rollback create image = %q, want the previously running image", daemon.createCalls[1])
+	}
+}
+
+func TestUpdateContainerFailsWithoutTouchingRunningContainerOnBuildError(t *testing.T) {
+	daemon := &fakeDockerDaemon{buildErr: "failed to build: no such file", startedImages: make(map[string]string)}
+	server := httptest.NewServer(daemon.handler())
+	defer server.Close()
+
+	client, err := docker.NewClient(server.URL, "", server.Client(), nil)
+	if err != nil {
+		t.Fatalf("docker.NewClient() error = %v", err)
+	}
+
+	m := &Monitor{
+		Name:   "default",
+		Conf:   &InstanceConfig{Container: "game", GitDir: t.TempDir(), HealthTimeoutSeconds: 1},
+		Docker: client,
+	}
+	job := &Job{subscribers: make(map[chan string]struct{})}
+
+	_, err = m.updateContainer(job)
+	if err == nil {
+		t.Fatal("updateContainer() error = nil, want the build failure surfaced")
+	}
+	if !strings.Contains(err.Error(), "no such file") {
+		t.Errorf("updateContainer() error = %v, want it to surface the embedded build error", err)
+	}
+
+	daemon.mu.Lock()
+	defer daemon.mu.Unlock()
+	if len(daemon.createCalls) != 0 {
+		t.Errorf("containers created = %d, want 0 (container must not be recreated from a failed build)", len(daemon.createCalls))
+	}
+}
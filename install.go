@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Install registers the monitor's webhook with the configured Gitea or
+// GitHub repository using a personal access token, à la contractor's own
+// install subcommand.
+func Install(config *InstanceConfig) error {
+	if config.GitAPIBaseURL == "" || config.GitOwner == "" || config.GitRepo == "" || config.GitToken == "" {
+		return fmt.Errorf("git_api_base_url, git_owner, git_repo and git_token must all be set in config.json")
+	}
+	if config.WebhookURL == "" {
+		return fmt.Errorf("webhook_url must be set in config.json")
+	}
+	if config.WebhookSecret == "" {
+		return fmt.Errorf("webhook_secret must be set in config.json")
+	}
+
+	body, err := hookRequestBody(config)
+	if err != nil {
+		return fmt.Errorf("failed to build hook payload: %v", err)
+	}
+
+	url := fmt.Sprintf("%s/repos/%s/%s/hooks", config.GitAPIBaseURL, config.GitOwner, config.GitRepo)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "token "+config.GitToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s (%v)", config.GitProvider, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s rejected hook registration: %s (%s)", config.GitProvider, resp.Status, string(respBody))
+	}
+
+	fmt.Printf("webhook registered on %s/%s\n", config.GitOwner, config.GitRepo)
+	return nil
+}
+
+// hookRequestBody builds the provider-specific JSON body for registering a
+// push + pull-request webhook. Gitea and GitHub use slightly different hook
+// creation schemas.
+func hookRequestBody(config *InstanceConfig) ([]byte, error) {
+	events := []string{"push", "pull_request"}
+
+	switch config.GitProvider {
+	case "gitea":
+		return json.Marshal(map[string]interface{}{
+			"type":   "gitea",
+			"active": true,
+			"events": events,
+			"config": map[string]string{
+				"url":          config.WebhookURL,
+				"content_type": "json",
+				"secret":       config.WebhookSecret,
+			},
+		})
+	case "github", "":
+		return json.Marshal(map[string]interface{}{
+			"name":   "web",
+			"active": true,
+			"events": events,
+			"config": map[string]string{
+				"url":          config.WebhookURL,
+				"content_type": "json",
+				"secret":       config.WebhookSecret,
+				"insecure_ssl": "0",
+			},
+		})
+	default:
+		return nil, fmt.Errorf("unknown git_provider %q", config.GitProvider)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"docker.io/go-docker"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// DefaultInstance is the name unprefixed routes (e.g. POST /update) alias
+// to, so single-server deployments keep working unchanged.
+const DefaultInstance = "default"
+
+// Registry holds one Monitor per configured instance, keyed by name.
+type Registry struct {
+	monitors map[string]*Monitor
+	names    []string
+}
+
+// NewRegistry builds a Monitor for every instance in config, all sharing
+// the same Docker client.
+func NewRegistry(config *Config, client *docker.Client) (*Registry, error) {
+	if len(config.Instances) == 0 {
+		return nil, fmt.Errorf("config.json must define at least one instance")
+	}
+
+	reg := &Registry{monitors: make(map[string]*Monitor, len(config.Instances))}
+	for name, instConf := range config.Instances {
+		reg.monitors[name] = &Monitor{
+			Name:   name,
+			Conf:   instConf,
+			Docker: client,
+			Jobs:   NewJobManager(name),
+		}
+		reg.names = append(reg.names, name)
+	}
+	sort.Strings(reg.names)
+
+	return reg, nil
+}
+
+func (reg *Registry) Get(name string) (*Monitor, bool) {
+	m, ok := reg.monitors[name]
+	return m, ok
+}
+
+// withInstance wraps a Monitor method as an http.HandlerFunc bound to a
+// fixed instance name, or, when name is empty, to the "{name}" URL param.
+func (reg *Registry) withInstance(name string, handler func(m *Monitor, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		instanceName := name
+		if instanceName == "" {
+			instanceName = chi.URLParam(r, "name")
+		}
+
+		m, ok := reg.Get(instanceName)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			render.Render(w, r, NewResponse(false, fmt.Sprintf("Unknown instance %q", instanceName)))
+			return
+		}
+
+		handler(m, w, r)
+	}
+}
+
+// mountInstanceRoutes mounts the per-server routes onto r, bound to the
+// given instance name (or, if empty, to the router's "{name}" URL param).
+// Each route requires auth's matching scope, and the mutating ones are
+// audit-logged.
+func mountInstanceRoutes(r chi.Router, reg *Registry, auth *Authenticator, instance string) {
+	r.With(auth.requireScope(scopeStart)).Post("/start", audit(reg.withInstance(instance, (*Monitor).Start)))
+	r.With(auth.requireScope(scopeStop)).Post("/stop", audit(reg.withInstance(instance, (*Monitor).Stop)))
+	r.With(auth.requireScope(scopeUpdate)).Post("/update", audit(reg.withInstance(instance, (*Monitor).Update)))
+	r.With(auth.requireScope(scopeRestoreSave)).Post("/restoresave", audit(reg.withInstance(instance, (*Monitor).RestoreSave)))
+	r.With(auth.requireScope(scopeRead)).Get("/commit", reg.withInstance(instance, (*Monitor).Commit))
+	r.With(auth.requireScope(scopeRead)).Get("/is_running", reg.withInstance(instance, (*Monitor).IsRunning))
+
+	r.With(auth.requireScope(scopeRead)).Get("/jobs", reg.withInstance(instance, (*Monitor).ListJobs))
+	r.With(auth.requireScope(scopeRead)).Get("/jobs/{id}", reg.withInstance(instance, (*Monitor).GetJob))
+	r.With(auth.requireScope(scopeRead)).Get("/jobs/{id}/logs", reg.withInstance(instance, (*Monitor).JobLogs))
+}
+
+// InstanceSummary is one entry of GET /instances.
+type InstanceSummary struct {
+	Name          string `json:"name"`
+	Running       bool   `json:"running"`
+	CommitSHA     string `json:"commit_sha,omitempty"`
+	CommitMessage string `json:"commit_message,omitempty"`
+	LastJobKind   string `json:"last_job_kind,omitempty"`
+	LastJobStatus string `json:"last_job_status,omitempty"`
+}
+
+func (m *Monitor) summary() InstanceSummary {
+	s := InstanceSummary{Name: m.Name}
+
+	if info, err := m.Docker.ContainerInspect(context.Background(), m.Conf.Container); err == nil {
+		s.Running = info.State.Running
+	}
+
+	if sha, summary, _, err := m.headCommit(); err == nil {
+		s.CommitSHA = sha
+		s.CommitMessage = summary
+	}
+
+	if job := m.Jobs.Latest(); job != nil {
+		view := job.view(false)
+		s.LastJobKind = string(view.Kind)
+		s.LastJobStatus = string(view.Status)
+	}
+
+	return s
+}
+
+func (reg *Registry) ListInstances(w http.ResponseWriter, r *http.Request) {
+	summaries := make([]InstanceSummary, 0, len(reg.names))
+	for _, name := range reg.names {
+		summaries = append(summaries, reg.monitors[name].summary())
+	}
+	render.Render(w, r, NewResponse(true, summaries))
+}
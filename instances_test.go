@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestNewRegistryRequiresAtLeastOneInstance(t *testing.T) {
+	if _, err := NewRegistry(&Config{}, nil); err == nil {
+		t.Error("NewRegistry() error = nil for a config with no instances, want an error")
+	}
+}
+
+func TestNewRegistryBuildsAMonitorPerInstance(t *testing.T) {
+	config := &Config{
+		Instances: map[string]*InstanceConfig{
+			"b": {Container: "game-b"},
+			"a": {Container: "game-a"},
+		},
+	}
+
+	reg, err := NewRegistry(config, nil)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	if want := []string{"a", "b"}; !equalStrings(reg.names, want) {
+		t.Errorf("reg.names = %v, want sorted %v", reg.names, want)
+	}
+
+	for name, instConf := range config.Instances {
+		m, ok := reg.Get(name)
+		if !ok {
+			t.Fatalf("Get(%q) = not found", name)
+		}
+		if m.Name != name || m.Conf != instConf {
+			t.Errorf("Get(%q) = %+v, want a Monitor for that instance's config", name, m)
+		}
+		if m.Jobs == nil {
+			t.Errorf("Get(%q).Jobs = nil, want a JobManager", name)
+		}
+	}
+
+	if _, ok := reg.Get("missing"); ok {
+		t.Error("Get() of an unconfigured instance = found, want not found")
+	}
+}
+
+func TestWithInstanceRejectsUnknownInstance(t *testing.T) {
+	reg, err := NewRegistry(&Config{
+		Instances: map[string]*InstanceConfig{"default": {Container: "game"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	called := false
+	handler := reg.withInstance("", func(m *Monitor, w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	router := chi.NewRouter()
+	router.Get("/{name}/ping", handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/unknown/ping", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if called {
+		t.Error("handler was invoked for an unknown instance")
+	}
+}
+
+func TestWithInstanceDispatchesToTheNamedInstance(t *testing.T) {
+	reg, err := NewRegistry(&Config{
+		Instances: map[string]*InstanceConfig{"default": {Container: "game"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	var got *Monitor
+	handler := reg.withInstance("", func(m *Monitor, w http.ResponseWriter, r *http.Request) {
+		got = m
+		w.WriteHeader(http.StatusOK)
+	})
+
+	router := chi.NewRouter()
+	router.Get("/{name}/ping", handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/default/ping", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	want, _ := reg.Get("default")
+	if got != want {
+		t.Errorf("handler received Monitor %+v, want %+v", got, want)
+	}
+}
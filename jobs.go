@@ -0,0 +1,434 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// JobKind identifies which monitor action a job performs.
+type JobKind string
+
+const (
+	JobStart       JobKind = "start"
+	JobStop        JobKind = "stop"
+	JobUpdate      JobKind = "update"
+	JobRestoreSave JobKind = "restoresave"
+)
+
+// JobStatus is the lifecycle state of a Job.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// jobHistorySize bounds how many past jobs of each kind are kept around.
+const jobHistorySize = 20
+
+// jobLogBacklog bounds how many log lines are buffered per job.
+const jobLogBacklog = 1000
+
+var (
+	jobsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "monitor_jobs_total",
+		Help: "Number of jobs run, by instance, kind and final status.",
+	}, []string{"instance", "kind", "status"})
+
+	jobsRunning = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "monitor_jobs_running",
+		Help: "Number of jobs currently running, by instance and kind.",
+	}, []string{"instance", "kind"})
+
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "monitor_job_duration_seconds",
+		Help: "Job run duration in seconds, by instance, kind and final status.",
+	}, []string{"instance", "kind", "status"})
+)
+
+// Job tracks the lifecycle and captured output of a single script run.
+type Job struct {
+	ID       string            `json:"id"`
+	Kind     JobKind           `json:"kind"`
+	Args     []string          `json:"args,omitempty"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	QueuedAt time.Time         `json:"queued_at"`
+
+	mu          sync.Mutex
+	status      JobStatus
+	exitCode    int
+	err         string
+	startedAt   time.Time
+	endedAt     time.Time
+	log         []string
+	subscribers map[chan string]struct{}
+	done        chan struct{}
+}
+
+// JobView is the JSON-safe snapshot of a Job returned over the API.
+type JobView struct {
+	ID        string            `json:"id"`
+	Kind      JobKind           `json:"kind"`
+	Status    JobStatus         `json:"status"`
+	Args      []string          `json:"args,omitempty"`
+	Meta      map[string]string `json:"meta,omitempty"`
+	ExitCode  int               `json:"exit_code"`
+	Error     string            `json:"error,omitempty"`
+	QueuedAt  time.Time         `json:"queued_at"`
+	StartedAt time.Time         `json:"started_at,omitempty"`
+	EndedAt   time.Time         `json:"ended_at,omitempty"`
+	Log       []string          `json:"log,omitempty"`
+}
+
+func (j *Job) view(withLog bool) JobView {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	v := JobView{
+		ID:        j.ID,
+		Kind:      j.Kind,
+		Status:    j.status,
+		Args:      j.Args,
+		Meta:      j.Meta,
+		ExitCode:  j.exitCode,
+		Error:     j.err,
+		QueuedAt:  j.QueuedAt,
+		StartedAt: j.startedAt,
+		EndedAt:   j.endedAt,
+	}
+	if withLog {
+		v.Log = append([]string(nil), j.log...)
+	}
+	return v
+}
+
+func (j *Job) appendLog(line string) {
+	j.mu.Lock()
+	j.log = append(j.log, line)
+	if len(j.log) > jobLogBacklog {
+		j.log = j.log[len(j.log)-jobLogBacklog:]
+	}
+	subs := make([]chan string, 0, len(j.subscribers))
+	for ch := range j.subscribers {
+		subs = append(subs, ch)
+	}
+	j.mu.Unlock()
+
+	for _, ch := range subs {
+		select {
+		case ch <- line:
+		default:
+			// Slow subscriber; drop the line rather than block the job.
+		}
+	}
+}
+
+// tailAndSubscribe atomically returns the buffered backlog and registers ch
+// as a subscriber to lines appended afterwards, so no line appended between
+// the two can be missed by the caller.
+func (j *Job) tailAndSubscribe() ([]string, chan string) {
+	ch := make(chan string, 256)
+	j.mu.Lock()
+	tail := append([]string(nil), j.log...)
+	j.subscribers[ch] = struct{}{}
+	j.mu.Unlock()
+	return tail, ch
+}
+
+func (j *Job) unsubscribe(ch chan string) {
+	j.mu.Lock()
+	delete(j.subscribers, ch)
+	j.mu.Unlock()
+	close(ch)
+}
+
+func (j *Job) isDone() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// jobRunner performs the actual work for a job and returns its exit code.
+type jobRunner func(job *Job) (int, error)
+
+type queuedJob struct {
+	job *Job
+	run jobRunner
+}
+
+// JobManager serializes script runs onto a background worker, keeps a
+// bounded history per kind, and prevents two jobs of the same kind from
+// running concurrently.
+type JobManager struct {
+	instance string
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	history map[JobKind][]*Job
+	active  map[JobKind]*Job
+	seq     int
+	queue   chan queuedJob
+}
+
+func NewJobManager(instance string) *JobManager {
+	jm := &JobManager{
+		instance: instance,
+		jobs:     make(map[string]*Job),
+		history:  make(map[JobKind][]*Job),
+		active:   make(map[JobKind]*Job),
+		queue:    make(chan queuedJob, 64),
+	}
+	go jm.worker()
+	return jm
+}
+
+// Enqueue schedules run to be executed for the given kind. If a job of the
+// same kind is already queued or running, that job is returned instead and
+// the bool result is false. meta is attached to the job as-is, for
+// auditability, and may be nil.
+func (jm *JobManager) Enqueue(kind JobKind, args []string, meta map[string]string, run jobRunner) (*Job, bool) {
+	jm.mu.Lock()
+	if active, ok := jm.active[kind]; ok {
+		jm.mu.Unlock()
+		return active, false
+	}
+
+	jm.seq++
+	job := &Job{
+		ID:          fmt.Sprintf("%s-%d", kind, jm.seq),
+		Kind:        kind,
+		Args:        args,
+		Meta:        meta,
+		QueuedAt:    time.Now(),
+		status:      JobQueued,
+		subscribers: make(map[chan string]struct{}),
+		done:        make(chan struct{}),
+	}
+
+	jm.jobs[job.ID] = job
+	jm.active[kind] = job
+	jm.history[kind] = append(jm.history[kind], job)
+	if len(jm.history[kind]) > jobHistorySize {
+		evicted := jm.history[kind][0]
+		jm.history[kind] = jm.history[kind][len(jm.history[kind])-jobHistorySize:]
+		delete(jm.jobs, evicted.ID)
+	}
+	jm.mu.Unlock()
+
+	jm.queue <- queuedJob{job, run}
+	return job, true
+}
+
+func (jm *JobManager) Get(id string) (*Job, bool) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	job, ok := jm.jobs[id]
+	return job, ok
+}
+
+func (jm *JobManager) List() []*Job {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	var jobs []*Job
+	for _, byKind := range jm.history {
+		jobs = append(jobs, byKind...)
+	}
+	return jobs
+}
+
+// Latest returns the most recently queued job across all kinds, or nil if
+// none has run yet.
+func (jm *JobManager) Latest() *Job {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	var latest *Job
+	for _, job := range jm.jobs {
+		if latest == nil || job.QueuedAt.After(latest.QueuedAt) {
+			latest = job
+		}
+	}
+	return latest
+}
+
+func (jm *JobManager) worker() {
+	for qj := range jm.queue {
+		jm.run(qj.job, qj.run)
+	}
+}
+
+func (jm *JobManager) run(job *Job, run jobRunner) {
+	job.mu.Lock()
+	job.status = JobRunning
+	job.startedAt = time.Now()
+	job.mu.Unlock()
+
+	jobsRunning.WithLabelValues(jm.instance, string(job.Kind)).Inc()
+	exitCode, err := run(job)
+	jobsRunning.WithLabelValues(jm.instance, string(job.Kind)).Dec()
+
+	job.mu.Lock()
+	job.exitCode = exitCode
+	job.endedAt = time.Now()
+	if err != nil {
+		job.status = JobFailed
+		job.err = err.Error()
+	} else {
+		job.status = JobSucceeded
+	}
+	status := job.status
+	duration := job.endedAt.Sub(job.startedAt)
+	job.mu.Unlock()
+
+	jobsTotal.WithLabelValues(jm.instance, string(job.Kind), string(status)).Inc()
+	jobDuration.WithLabelValues(jm.instance, string(job.Kind), string(status)).Observe(duration.Seconds())
+
+	close(job.done)
+
+	jm.mu.Lock()
+	if jm.active[job.Kind] == job {
+		delete(jm.active, job.Kind)
+	}
+	jm.mu.Unlock()
+}
+
+// lineWriter splits whatever is written to it into lines and appends each
+// complete line to a job's log as it arrives.
+type lineWriter struct {
+	job     *Job
+	pending string
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.pending += string(p)
+	for {
+		idx := strings.IndexByte(w.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		w.job.appendLog(strings.TrimRight(w.pending[:idx], "\r"))
+		w.pending = w.pending[idx+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) flush() {
+	if w.pending != "" {
+		w.job.appendLog(w.pending)
+		w.pending = ""
+	}
+}
+
+// runScript runs path with args, streaming combined stdout+stderr into the
+// job's log line by line, and returns its exit code.
+func runScript(job *Job, path string, args ...string) (int, error) {
+	cmd := exec.Command(path, args...)
+	writer := &lineWriter{job: job}
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+
+	err := cmd.Run()
+	writer.flush()
+
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), err
+		}
+		return -1, err
+	}
+	return 0, nil
+}
+
+func renderJob(w http.ResponseWriter, r *http.Request, job *Job, created bool) {
+	action := "queued"
+	if !created {
+		action = "attached to running job"
+	}
+	render.Render(w, r, NewResponse(true, map[string]interface{}{
+		"job_id": job.ID,
+		"status": string(job.view(false).Status),
+		"action": action,
+	}))
+}
+
+var jobLogsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+func (m *Monitor) ListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := m.Jobs.List()
+	views := make([]JobView, len(jobs))
+	for i, job := range jobs {
+		views[i] = job.view(false)
+	}
+	render.Render(w, r, NewResponse(true, views))
+}
+
+func (m *Monitor) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := m.Jobs.Get(chi.URLParam(r, "id"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		render.Render(w, r, NewResponse(false, "Unknown job"))
+		return
+	}
+	render.Render(w, r, NewResponse(true, job.view(true)))
+}
+
+func (m *Monitor) JobLogs(w http.ResponseWriter, r *http.Request) {
+	job, ok := m.Jobs.Get(chi.URLParam(r, "id"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		render.Render(w, r, NewResponse(false, "Unknown job"))
+		return
+	}
+
+	conn, err := jobLogsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	tail, ch := job.tailAndSubscribe()
+	defer job.unsubscribe(ch)
+
+	for _, line := range tail {
+		if conn.WriteMessage(websocket.TextMessage, []byte(line)) != nil {
+			return
+		}
+	}
+	if job.isDone() {
+		return
+	}
+
+	for {
+		select {
+		case line, ok := <-ch:
+			if !ok {
+				return
+			}
+			if conn.WriteMessage(websocket.TextMessage, []byte(line)) != nil {
+				return
+			}
+		case <-job.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func instantRunner(job *Job) (int, error) {
+	return 0, nil
+}
+
+// enqueueAndWait retries Enqueue until it actually creates a new job (rather
+// than deduping onto one still finishing up), then waits for it to run to
+// completion.
+func enqueueAndWait(t *testing.T, jm *JobManager, kind JobKind, run jobRunner) *Job {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		job, created := jm.Enqueue(kind, nil, nil, run)
+		if created {
+			<-job.done
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Enqueue(%s) never returned a fresh job", kind)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestJobManagerEnqueueDedupesWhileActive(t *testing.T) {
+	jm := NewJobManager("test")
+	release := make(chan struct{})
+
+	first, created := jm.Enqueue(JobStart, nil, nil, func(job *Job) (int, error) {
+		<-release
+		return 0, nil
+	})
+	if !created {
+		t.Fatal("Enqueue() created = false on an empty JobManager, want true")
+	}
+
+	second, created := jm.Enqueue(JobStart, nil, nil, instantRunner)
+	if created {
+		t.Fatal("Enqueue() created = true while a job of the same kind is still running, want false")
+	}
+	if second != first {
+		t.Fatalf("Enqueue() returned a different job while deduping: got %s, want %s", second.ID, first.ID)
+	}
+
+	close(release)
+	<-first.done
+
+	third := enqueueAndWait(t, jm, JobStart, instantRunner)
+	if third.ID == first.ID {
+		t.Fatal("Enqueue() after the active job finished returned the same job instead of a new one")
+	}
+}
+
+func TestJobManagerEnqueueEvictsFromJobsWithHistory(t *testing.T) {
+	jm := NewJobManager("test")
+
+	total := jobHistorySize + 5
+	ids := make([]string, 0, total)
+	for i := 0; i < total; i++ {
+		job := enqueueAndWait(t, jm, JobStart, instantRunner)
+		ids = append(ids, job.ID)
+	}
+
+	if len(jm.jobs) != jobHistorySize {
+		t.Errorf("len(jm.jobs) = %d, want %d (bounded to history size)", len(jm.jobs), jobHistorySize)
+	}
+	if len(jm.history[JobStart]) != jobHistorySize {
+		t.Errorf("len(jm.history[JobStart]) = %d, want %d", len(jm.history[JobStart]), jobHistorySize)
+	}
+
+	evicted := ids[:total-jobHistorySize]
+	kept := ids[total-jobHistorySize:]
+
+	for _, id := range evicted {
+		if _, ok := jm.Get(id); ok {
+			t.Errorf("Get(%s) = found, want evicted along with its history entry", id)
+		}
+	}
+	for _, id := range kept {
+		if _, ok := jm.Get(id); !ok {
+			t.Errorf("Get(%s) = not found, want it still retained", id)
+		}
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		flush  bool
+		want   []string
+	}{
+		{"trailing newline", []string{"hello\n"}, false, []string{"hello"}},
+		{"crlf line ending", []string{"hello\r\n"}, false, []string{"hello"}},
+		{"line split across writes", []string{"hel", "lo\n"}, false, []string{"hello"}},
+		{"multiple lines in one write", []string{"a\nb\nc\n"}, false, []string{"a", "b", "c"}},
+		{"partial line kept pending until flush", []string{"partial"}, true, []string{"partial"}},
+		{"partial line without flush is not logged", []string{"partial"}, false, nil},
+		{"flush is a no-op after a trailing newline", []string{"done\n"}, true, []string{"done"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &Job{subscribers: make(map[chan string]struct{})}
+			w := &lineWriter{job: job}
+
+			for _, chunk := range tt.writes {
+				n, err := w.Write([]byte(chunk))
+				if err != nil {
+					t.Fatalf("Write(%q) error = %v", chunk, err)
+				}
+				if n != len(chunk) {
+					t.Fatalf("Write(%q) = %d, want %d", chunk, n, len(chunk))
+				}
+			}
+			if tt.flush {
+				w.flush()
+			}
+
+			if !equalStrings(job.log, tt.want) {
+				t.Errorf("job log = %v, want %v", job.log, tt.want)
+			}
+		})
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
 	"strings"
 
 	"docker.io/go-docker"
@@ -17,12 +16,12 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/render"
-	"github.com/goji/httpauth"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-type Config struct {
-	Password          string `json:"password"`
+// InstanceConfig describes one managed server: its scripts or container,
+// its git checkout, and its webhook settings.
+type InstanceConfig struct {
 	StartScript       string `json:"start_script"`
 	StopScript        string `json:"stop_script"`
 	UpdateScript      string `json:"update_script"`
@@ -30,6 +29,30 @@ type Config struct {
 	GitDir            string `json:"git_dir"`
 	PidFile           string `json:"pid_file"`
 	Container         string `json:"container"`
+
+	WebhookSecret string `json:"webhook_secret"`
+	WebhookBranch string `json:"webhook_branch"`
+
+	GitProvider   string `json:"git_provider"` // "github" or "gitea"
+	GitAPIBaseURL string `json:"git_api_base_url"`
+	GitOwner      string `json:"git_owner"`
+	GitRepo       string `json:"git_repo"`
+	GitToken      string `json:"git_token"`
+	WebhookURL    string `json:"webhook_url"`
+
+	Mode                 string `json:"mode"` // "script" (default) or "docker"
+	Image                string `json:"image"`
+	StopTimeoutSeconds   int    `json:"stop_timeout_seconds"`
+	HealthTimeoutSeconds int    `json:"health_timeout_seconds"`
+}
+
+// Config is the top-level config.json shape: one admin password shared by
+// every route, a set of scoped API tokens, and a named instance per
+// managed server.
+type Config struct {
+	Password  string                     `json:"password"`
+	Tokens    []TokenConfig              `json:"tokens"`
+	Instances map[string]*InstanceConfig `json:"instances"`
 }
 
 type Response struct {
@@ -49,47 +72,25 @@ func NewResponse(success bool, message interface{}) render.Renderer {
 }
 
 type Monitor struct {
-	Conf   *Config
+	Name   string
+	Conf   *InstanceConfig
 	Docker *docker.Client
+	Jobs   *JobManager
 }
 
 func (m *Monitor) Start(w http.ResponseWriter, r *http.Request) {
-	cmd := exec.Command(m.Conf.StartScript)
-
-	success := true
-	message := "Server has been started"
-	if err := cmd.Run(); err != nil {
-		success = false
-		message = fmt.Sprintf("Server failed to start (%v)", err)
-	}
-
-	render.Render(w, r, NewResponse(success, message))
+	job, created := m.Jobs.Enqueue(JobStart, nil, nil, m.startRunner())
+	renderJob(w, r, job, created)
 }
 
 func (m *Monitor) Stop(w http.ResponseWriter, r *http.Request) {
-	cmd := exec.Command(m.Conf.StopScript)
-
-	success := true
-	message := "Server has been stopped"
-	if err := cmd.Run(); err != nil {
-		success = false
-		message = fmt.Sprintf("Server failed to stop (%v)", err)
-	}
-
-	render.Render(w, r, NewResponse(success, message))
+	job, created := m.Jobs.Enqueue(JobStop, nil, nil, m.stopRunner())
+	renderJob(w, r, job, created)
 }
 
 func (m *Monitor) Update(w http.ResponseWriter, r *http.Request) {
-	cmd := exec.Command(m.Conf.UpdateScript)
-
-	success := true
-	message := "Server has been updated"
-	if err := cmd.Run(); err != nil {
-		success = false
-		message = fmt.Sprintf("Server failed to update (%v)", err)
-	}
-
-	render.Render(w, r, NewResponse(success, message))
+	job, created := m.Jobs.Enqueue(JobUpdate, nil, nil, m.updateRunner())
+	renderJob(w, r, job, created)
 }
 
 func (m *Monitor) RestoreSave(w http.ResponseWriter, r *http.Request) {
@@ -100,70 +101,107 @@ func (m *Monitor) RestoreSave(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cmd := exec.Command(m.Conf.RestoreSaveScript, ckey, date)
-
-	success := true
-	bmessage, err := cmd.CombinedOutput()
-	message := string(bmessage)
+	job, created := m.Jobs.Enqueue(JobRestoreSave, []string{ckey, date}, nil, func(job *Job) (int, error) {
+		return runScript(job, m.Conf.RestoreSaveScript, ckey, date)
+	})
+	renderJob(w, r, job, created)
+}
 
+// headCommit reads the summary, date and sha of GitDir's HEAD commit.
+func (m *Monitor) headCommit() (sha, summary, date string, err error) {
+	repo, err := git.PlainOpen(m.Conf.GitDir)
 	if err != nil {
-		success = false
-		message = fmt.Sprintf("Script failed to run: %v\n\n%v", err, message)
+		return "", "", "", fmt.Errorf("failed to open git repo (%v)", err)
 	}
 
-	render.Render(w, r, NewResponse(success, message))
-}
-
-func (m *Monitor) Commit(w http.ResponseWriter, r *http.Request) {
-	success, message := func() (bool, interface{}) {
-		repo, err := git.PlainOpen(m.Conf.GitDir)
-		if err != nil {
-			return false, fmt.Sprintf("Failed to open git repo (%v)", err)
-		}
+	head, err := repo.Head()
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to get HEAD ref (%v)", err)
+	}
 
-		head, err := repo.Head()
-		if err != nil {
-			return false, fmt.Sprintf("Failed to get HEAD ref (%v)", err)
-		}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to get HEAD commit (%v)", err)
+	}
 
-		commit, err := repo.CommitObject(head.Hash())
-		if err != nil {
-			return false, fmt.Sprintf("Failed to get HEAD commit (%v)", err)
-		}
+	summary = strings.SplitN(strings.TrimSpace(commit.Message), "\n", 2)[0]
+	date = commit.Committer.When.Format("Mon Jan 02 15:04:05 2006 -0700")
 
-		summary := strings.SplitN(strings.TrimSpace(commit.Message), "\n", 2)[0]
+	return commit.Hash.String(), summary, date, nil
+}
 
-		return true, map[string]string{
-			"message": summary,
-			"date":    commit.Committer.When.Format("Mon Jan 02 15:04:05 2006 -0700"),
-			"sha":     commit.Hash.String(),
-		}
-	}()
+func (m *Monitor) Commit(w http.ResponseWriter, r *http.Request) {
+	sha, summary, date, err := m.headCommit()
+	if err != nil {
+		render.Render(w, r, NewResponse(false, err.Error()))
+		return
+	}
 
-	render.Render(w, r, NewResponse(success, message))
+	render.Render(w, r, NewResponse(true, map[string]string{
+		"message": summary,
+		"date":    date,
+		"sha":     sha,
+	}))
 }
 
 func (m *Monitor) IsRunning(w http.ResponseWriter, r *http.Request) {
-	running := true
-
 	info, err := m.Docker.ContainerInspect(context.Background(), m.Conf.Container)
-	if err != nil || info.State.Running == false {
-		running = false
+	if err != nil {
+		render.Render(w, r, NewResponse(true, ContainerStatus{Running: false, Error: err.Error()}))
+		return
 	}
 
-	render.Render(w, r, NewResponse(true, running))
+	render.Render(w, r, NewResponse(true, ContainerStatus{
+		Running:      info.State.Running,
+		Status:       info.State.Status,
+		ExitCode:     info.State.ExitCode,
+		OOMKilled:    info.State.OOMKilled,
+		RestartCount: info.RestartCount,
+	}))
 }
 
-func main() {
+func readConfig() (*Config, error) {
 	file, err := ioutil.ReadFile("config.json")
 	if err != nil {
-		fmt.Printf("config read error: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("config read error: %v", err)
 	}
 
 	var config Config
-	if err = json.Unmarshal(file, &config); err != nil {
-		fmt.Printf("config parse error: %v\n", err)
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, fmt.Errorf("config parse error: %v", err)
+	}
+
+	return &config, nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "install" {
+		config, err := readConfig()
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+
+		instanceName := DefaultInstance
+		if len(os.Args) > 2 {
+			instanceName = os.Args[2]
+		}
+		instConf, ok := config.Instances[instanceName]
+		if !ok {
+			fmt.Printf("unknown instance %q\n", instanceName)
+			os.Exit(1)
+		}
+
+		if err := Install(instConf); err != nil {
+			fmt.Printf("install failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	config, err := readConfig()
+	if err != nil {
+		fmt.Printf("%v\n", err)
 		os.Exit(1)
 	}
 
@@ -173,9 +211,10 @@ func main() {
 		os.Exit(1)
 	}
 
-	monitor := &Monitor{
-		&config,
-		client,
+	registry, err := NewRegistry(config, client)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
 	}
 
 	fmt.Print("starting\n")
@@ -187,19 +226,25 @@ func main() {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(render.SetContentType(render.ContentTypeJSON))
-	r.Use(httpauth.SimpleBasicAuth("auth", config.Password))
 
 	// prometheus middleware
 	pm := chiprometheus.NewMiddleware("monitor")
 	r.Use(pm)
-	r.Handle("/metrics", promhttp.Handler())
-
-	r.Post("/start", monitor.Start)
-	r.Post("/stop", monitor.Stop)
-	r.Post("/update", monitor.Update)
-	r.Post("/restoresave", monitor.RestoreSave)
-	r.Get("/commit", monitor.Commit)
-	r.Get("/is_running", monitor.IsRunning)
+
+	// The webhook route verifies an HMAC signature of its own and must stay
+	// reachable without an operator credential, for every instance.
+	r.Post("/webhook", registry.withInstance(DefaultInstance, (*Monitor).Webhook))
+	r.Post("/instances/{name}/webhook", registry.withInstance("", (*Monitor).Webhook))
+
+	auth := NewAuthenticator(config)
+
+	r.With(auth.requireScope(scopeRead)).Handle("/metrics", promhttp.Handler())
+	r.With(auth.requireScope(scopeRead)).Get("/instances", registry.ListInstances)
+
+	mountInstanceRoutes(r, registry, auth, DefaultInstance)
+	r.Route("/instances/{name}", func(r chi.Router) {
+		mountInstanceRoutes(r, registry, auth, "")
+	})
 
 	http.ListenAndServe(":3889", r)
 }
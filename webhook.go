@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+	git "gopkg.in/src-d/go-git.v4"
+)
+
+// webhookPayload covers the subset of the Gitea and GitHub push and
+// pull-request-merged webhook payloads that the monitor cares about. The
+// two providers agree closely enough on shape that one struct covers both.
+type webhookPayload struct {
+	Ref    string `json:"ref"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+	Action      string `json:"action"`
+	PullRequest *struct {
+		Merged bool `json:"merged"`
+		Base   struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+		MergeCommitSHA string `json:"merge_commit_sha"`
+	} `json:"pull_request"`
+}
+
+// branchAndCommits extracts the target branch and before/after commits a
+// push or merged-pull-request event applies to. trigger is false for events
+// that shouldn't cause an update, such as an unmerged pull request.
+func (p *webhookPayload) branchAndCommits(event string) (branch, before, after string, trigger bool) {
+	switch event {
+	case "push":
+		return strings.TrimPrefix(p.Ref, "refs/heads/"), p.Before, p.After, true
+	case "pull_request":
+		if p.PullRequest == nil || p.Action != "closed" || !p.PullRequest.Merged {
+			return "", "", "", false
+		}
+		return p.PullRequest.Base.Ref, "", p.PullRequest.MergeCommitSHA, true
+	default:
+		return "", "", "", false
+	}
+}
+
+// verifyWebhookSignature checks the X-Hub-Signature-256 header (used by both
+// Gitea and GitHub) against an HMAC-SHA256 of body keyed by secret.
+func verifyWebhookSignature(secret string, body []byte, signatureHeader string) bool {
+	const prefix = "sha256="
+	if secret == "" || !strings.HasPrefix(signatureHeader, prefix) {
+		return false
+	}
+
+	given, err := hex.DecodeString(strings.TrimPrefix(signatureHeader, prefix))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(given, mac.Sum(nil))
+}
+
+// currentBranch returns the short name of the branch GitDir's HEAD points
+// at, used as the default webhook filter branch.
+func (m *Monitor) currentBranch() (string, error) {
+	repo, err := git.PlainOpen(m.Conf.GitDir)
+	if err != nil {
+		return "", err
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+
+	return head.Name().Short(), nil
+}
+
+// Webhook receives Gitea/GitHub push and pull-request-merged events and
+// enqueues an Update job when the pushed ref matches the configured (or
+// current HEAD) branch. It bypasses basic auth and instead authenticates
+// the sender via an HMAC signature of the request body.
+func (m *Monitor) Webhook(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Render(w, r, NewResponse(false, "Failed to read request body"))
+		return
+	}
+
+	if !verifyWebhookSignature(m.Conf.WebhookSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+		w.WriteHeader(http.StatusUnauthorized)
+		render.Render(w, r, NewResponse(false, "Invalid webhook signature"))
+		return
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Render(w, r, NewResponse(false, "Invalid webhook payload"))
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	if event == "" {
+		event = r.Header.Get("X-Gitea-Event")
+	}
+
+	branch, before, after, trigger := payload.branchAndCommits(event)
+	if !trigger {
+		render.Render(w, r, NewResponse(true, "Ignored: not a push or merged pull request"))
+		return
+	}
+
+	target := m.Conf.WebhookBranch
+	if target == "" {
+		target, err = m.currentBranch()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			render.Render(w, r, NewResponse(false, fmt.Sprintf("Failed to determine target branch (%v)", err)))
+			return
+		}
+	}
+
+	if branch != target {
+		render.Render(w, r, NewResponse(true, fmt.Sprintf("Ignored: branch %q does not match %q", branch, target)))
+		return
+	}
+
+	meta := map[string]string{
+		"event":  event,
+		"sender": payload.Sender.Login,
+		"ref":    payload.Ref,
+		"before": before,
+		"after":  after,
+	}
+
+	job, created := m.Jobs.Enqueue(JobUpdate, nil, meta, m.updateRunner())
+	renderJob(w, r, job, created)
+}
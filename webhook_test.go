@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	secret := "s3cr3t"
+	body := []byte(`{"ref":"refs/heads/master"}`)
+
+	tests := []struct {
+		name      string
+		secret    string
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"valid signature", secret, body, sign(secret, body), true},
+		{"wrong secret", secret, body, sign("other", body), false},
+		{"tampered body", secret, []byte(`{"ref":"refs/heads/evil"}`), sign(secret, body), false},
+		{"empty configured secret", "", body, sign(secret, body), false},
+		{"missing header", secret, body, "", false},
+		{"missing sha256 prefix", secret, body, hex.EncodeToString(sha256.New().Sum(body)), false},
+		{"non-hex signature", secret, body, "sha256=not-hex", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyWebhookSignature(tt.secret, tt.body, tt.signature); got != tt.want {
+				t.Errorf("verifyWebhookSignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}